@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
@@ -29,18 +30,35 @@ type GitClient struct {
 	progress bool
 }
 
+// clone returns a repository backed by c.dir, fetching into an existing
+// clone there rather than recloning from scratch, so older commits stay
+// reachable for diffChangedPaths.
 func (c *GitClient) clone(ctx context.Context) (*git.Repository, error) {
+	storage := filesystem.NewStorage(osfs.New(c.dir), cache.NewObjectLRUDefault())
+
+	if repo, err := git.Open(storage, nil); err == nil {
+		slog.Debug("fetch repo", "url", c.url, "branch", c.branch, "dir", c.dir)
+		err = repo.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: "origin",
+			RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", c.branch, c.branch))},
+			Auth:       c.auth,
+			Depth:      1,
+			Tags:       git.NoTags,
+			Progress:   c.progressWriter(),
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return nil, err
+		}
+		return repo, nil
+	}
+
 	slog.Debug("clone repo", "url", c.url, "branch", c.branch, "to", c.dir)
 
 	err := os.RemoveAll(c.dir)
 	if err != nil {
 		return nil, err
 	}
-	storage := filesystem.NewStorage(osfs.New(c.dir), cache.NewObjectLRUDefault())
-	var progress sideband.Progress
-	if c.progress {
-		progress = os.Stderr
-	}
+	storage = filesystem.NewStorage(osfs.New(c.dir), cache.NewObjectLRUDefault())
 	return git.CloneContext(ctx, storage, nil, &git.CloneOptions{
 		URL:           c.url,
 		Auth:          c.auth,
@@ -49,27 +67,34 @@ func (c *GitClient) clone(ctx context.Context) (*git.Repository, error) {
 		NoCheckout:    true,
 		Depth:         1,
 		Tags:          git.NoTags,
-		Progress:      progress,
+		Progress:      c.progressWriter(),
 	})
 }
 
-func getGitAuth(conf *Config) (transport.AuthMethod, error) {
-	authType := conf.Repo.Auth["type"]
+func (c *GitClient) progressWriter() sideband.Progress {
+	if c.progress {
+		return os.Stderr
+	}
+	return nil
+}
+
+func getGitAuth(auth map[string]string) (transport.AuthMethod, error) {
+	authType := auth["type"]
 	switch authType {
 	case "ssh":
-		sshAuth, err := ssh.NewPublicKeys(conf.Repo.Auth["user"], []byte(conf.Repo.Auth["privateKey"]), conf.Repo.Auth["password"])
+		sshAuth, err := ssh.NewPublicKeys(auth["user"], []byte(auth["privateKey"]), auth["password"])
 		if err != nil {
 			return nil, err
 		}
 		return sshAuth, nil
 	case "http-basic-auth":
 		return &http.BasicAuth{
-			Username: conf.Repo.Auth["username"],
-			Password: conf.Repo.Auth["password"],
+			Username: auth["username"],
+			Password: auth["password"],
 		}, nil
 	case "http-token-auth":
 		return &http.TokenAuth{
-			Token: conf.Repo.Auth["token"],
+			Token: auth["token"],
 		}, nil
 	case "", "none":
 		return nil, nil
@@ -78,19 +103,18 @@ func getGitAuth(conf *Config) (transport.AuthMethod, error) {
 	}
 }
 
-func newGitClient(conf *Config) (*GitClient, error) {
-	repoUrl := conf.Repo.Url
-	if repoUrl == "" {
-		return nil, fmt.Errorf("repo.url is required")
+func newGitClient(repo *RepoSpec) (*GitClient, error) {
+	if repo.Url == "" {
+		return nil, fmt.Errorf("repos[].url is required")
 	}
-	branch := cmp.Or(conf.Repo.Branch, "master")
-	repoAuth, err := getGitAuth(conf)
+	branch := cmp.Or(repo.Branch, "master")
+	repoAuth, err := getGitAuth(repo.Auth)
 	if err != nil {
 		return nil, err
 	}
-	repoDir := cmp.Or(conf.Repo.Dir, filepath.Join(os.TempDir(), path.Base(strings.TrimRight(repoUrl, "/"))))
+	repoDir := cmp.Or(repo.Dir, filepath.Join(os.TempDir(), repo.Name+"-"+path.Base(strings.TrimRight(repo.Url, "/"))))
 
-	if !conf.Repo.Force {
+	if !repo.Force {
 		_, err = os.Stat(repoDir)
 		if err != nil {
 			if !os.IsNotExist(err) {
@@ -102,10 +126,10 @@ func newGitClient(conf *Config) (*GitClient, error) {
 	}
 
 	return &GitClient{
-		url:      repoUrl,
+		url:      repo.Url,
 		branch:   branch,
 		auth:     repoAuth,
 		dir:      repoDir,
-		progress: conf.Repo.Progress,
+		progress: repo.Progress,
 	}, nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// applyHelmSource renders the chart at src.Path with the values at
+// src.Values and applies each rendered document through applyFile, using
+// a hash of the document as its file-id.
+func (o *Operator) applyHelmSource(ctx context.Context, tree *object.Tree, dotNops *DotNops, commitId string, src Source, seenFileIds map[string]struct{}) error {
+	chartDir, err := checkoutTree(tree, src.Path)
+	if err != nil {
+		return fmt.Errorf("checkout chart: %v", err)
+	}
+	defer os.RemoveAll(chartDir)
+
+	chart, err := loader.Load(chartDir)
+	if err != nil {
+		return fmt.Errorf("load chart: %v", err)
+	}
+
+	values := chartutil.Values{}
+	if src.Values != "" {
+		valuesDir, err := checkoutTree(tree, filepath.Dir(src.Values))
+		if err != nil {
+			return fmt.Errorf("checkout values: %v", err)
+		}
+		defer os.RemoveAll(valuesDir)
+
+		values, err = chartutil.ReadValuesFile(filepath.Join(valuesDir, filepath.Base(src.Values)))
+		if err != nil {
+			return fmt.Errorf("read values: %v", err)
+		}
+	}
+
+	install := action.NewInstall(new(action.Configuration))
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Namespace = dotNops.Namespace
+	install.ReleaseName = chart.Name()
+
+	rel, err := install.RunWithContext(ctx, chart, values)
+	if err != nil {
+		return fmt.Errorf("render chart: %v", err)
+	}
+
+	var firstErr error
+	for i, doc := range strings.Split(rel.Manifest, "\n---\n") {
+		doc = strings.TrimSpace(doc)
+		if doc == "" {
+			continue
+		}
+
+		fileId := fmt.Sprintf("%x", sha256.Sum256([]byte(doc)))
+		seenFileIds[fileId] = struct{}{}
+
+		err := o.applyFile(ctx, dotNops, commitId, fileId, []byte(doc))
+		if err != nil {
+			slog.Warn("apply rendered document", "source", src.Path, "index", i, "err", err)
+			firstErr = cmp.Or(firstErr, err)
+		}
+	}
+
+	return firstErr
+}
+
+// checkoutTree writes the files under subPath in tree to a new temp
+// directory and returns its path, for tooling that needs a real filesystem.
+func checkoutTree(tree *object.Tree, subPath string) (string, error) {
+	root := tree
+	if subPath != "" && subPath != "." {
+		var err error
+		root, err = tree.Tree(subPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "knops-chart-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = root.Files().ForEach(func(f *object.File) error {
+		data, err := readTreeFile(f)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(dir, f.Name)
+		err = os.MkdirAll(filepath.Dir(dest), 0o755)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(dest, data, 0o644)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
+	"k8s.io/utils/ptr"
 )
 
 type KubeClient struct {
 	name       string
 	di         dynamic.Interface
+	dc         discovery.DiscoveryInterface
 	restMapper *restmapper.DeferredDiscoveryRESTMapper
+
+	mu       sync.Mutex
+	kindGVKs map[string]schema.GroupVersionKind
 }
 
 func (c *KubeClient) resourceInterface(gvk schema.GroupVersionKind, ns string) (dynamic.ResourceInterface, error) {
@@ -59,6 +68,79 @@ func (c *KubeClient) update(ctx context.Context, obj *unstructured.Unstructured)
 	return ri.Update(ctx, obj, metav1.UpdateOptions{FieldManager: c.name})
 }
 
+// ssaApply applies obj via server-side apply, creating it if it does not exist.
+func (c *KubeClient) ssaApply(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ri, err := c.resourceInterface(obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: c.name, Force: ptr.To(true)})
+}
+
+// refreshRESTMapper drops the cached discovery info so newly registered kinds get picked up.
+func (c *KubeClient) refreshRESTMapper() {
+	c.restMapper.Reset()
+
+	c.mu.Lock()
+	clear(c.kindGVKs)
+	c.mu.Unlock()
+}
+
+// resolveKind finds the GroupVersionKind for a bare kind name via discovery.
+// Unlike get/create/update/ssaApply, whose GVK comes from decoding the
+// manifest's apiVersion, list is called with only a kind name (from
+// RepoSpec.Kinds) to go on, and resourceInterface's RESTMapping call resolves
+// an empty Group to the core group only, never the real one.
+func (c *KubeClient) resolveKind(kind string) (schema.GroupVersionKind, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gvk, ok := c.kindGVKs[kind]; ok {
+		return gvk, nil
+	}
+
+	_, lists, err := c.dc.ServerGroupsAndResources()
+	if err != nil && len(lists) == 0 {
+		return schema.GroupVersionKind{}, err
+	}
+
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if r.Kind == kind {
+				gvk := gv.WithKind(kind)
+				c.kindGVKs[kind] = gvk
+				return gvk, nil
+			}
+		}
+	}
+
+	return schema.GroupVersionKind{}, fmt.Errorf("kind not found via discovery: %s", kind)
+}
+
+func (c *KubeClient) list(ctx context.Context, gvk schema.GroupVersionKind, ns string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	ri, err := c.resourceInterface(gvk, ns)
+	if err != nil {
+		return nil, err
+	}
+	return ri.List(ctx, opts)
+}
+
+func (c *KubeClient) delete(ctx context.Context, gvk schema.GroupVersionKind, ns string, name string) error {
+	ri, err := c.resourceInterface(gvk, ns)
+	if err != nil {
+		return err
+	}
+	return ri.Delete(ctx, name, metav1.DeleteOptions{})
+}
+
 func newKubeClient(name string) (*KubeClient, error) {
 	// config, err := clientcmd.BuildConfigFromFlags("", os.ExpandEnv("$HOME/.kube/config"))
 	config, err := rest.InClusterConfig()
@@ -76,6 +158,8 @@ func newKubeClient(name string) (*KubeClient, error) {
 	return &KubeClient{
 		name:       name,
 		di:         di,
+		dc:         dc,
 		restMapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)),
+		kindGVKs:   make(map[string]schema.GroupVersionKind),
 	}, nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"flag"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/vizee/knops/pkg/job"
 )
 
 const (
@@ -21,25 +24,34 @@ func fatal(args ...any) {
 	os.Exit(1)
 }
 
+// RepoSpec configures one repo for the operator to reconcile in isolation.
+type RepoSpec struct {
+	Name     string            `yaml:"name"`
+	Url      string            `yaml:"url"`
+	Branch   string            `yaml:"branch"`
+	Auth     map[string]string `yaml:"auth"`
+	Dir      string            `yaml:"dir"`
+	Progress bool              `yaml:"progress"`
+	Force    bool              `yaml:"force"`
+
+	Kinds      []string `yaml:"kinds"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
 type Config struct {
 	Http struct {
-		Listen string `yaml:"listen"`
-		Key    string `yaml:"key"`
+		Listen         string            `yaml:"listen"`
+		Key            string            `yaml:"key"`
+		WebhookSecrets map[string]string `yaml:"webhookSecrets"`
 	} `yaml:"http"`
-	Repo struct {
-		Url      string            `yaml:"url"`
-		Branch   string            `yaml:"branch"`
-		Auth     map[string]string `yaml:"auth"`
-		Dir      string            `yaml:"dir"`
-		Progress bool              `yaml:"progress"`
-		Force    bool              `yaml:"force"`
-	} `yaml:"repo"`
+	Repos    []RepoSpec `yaml:"repos"`
 	Operator struct {
-		OnlyManaged bool     `yaml:"onlyManaged"`
-		AllowCreate bool     `yaml:"allowCreate"`
-		CacheFileId bool     `yaml:"cacheFileId"`
-		Kinds       []string `yaml:"kinds"`
-		Namespaces  []string `yaml:"namespaces"`
+		OnlyManaged bool                `yaml:"onlyManaged"`
+		AllowCreate bool                `yaml:"allowCreate"`
+		Schedule    map[string]job.Spec `yaml:"schedule"`
+		Prune       bool                `yaml:"prune"`
+		PruneDryRun bool                `yaml:"pruneDryRun"`
+		ApplyMode   string              `yaml:"applyMode"`
 	} `yaml:"operator"`
 	Debug bool `yaml:"debug"`
 }
@@ -58,8 +70,74 @@ func loadConfig(fname string) (*Config, error) {
 }
 
 type DeployJob struct {
-	ctx context.Context
-	res chan error
+	ctx   context.Context
+	res   chan error
+	after string
+}
+
+// enqueueDeploy submits a deploy to deployJobs and waits for it to finish.
+func enqueueDeploy(ctx context.Context, deployJobs chan<- DeployJob) error {
+	res := make(chan error, 1)
+	select {
+	case deployJobs <- DeployJob{ctx: ctx, res: res}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-res
+}
+
+// reconciler pairs one repo's Operator with its serialized deploy queue.
+type reconciler struct {
+	name       string
+	operator   *Operator
+	deployJobs chan DeployJob
+}
+
+// newReconciler builds a reconciler for repo and starts its deploy worker.
+func newReconciler(repo *RepoSpec, conf *Config, kubeClient *KubeClient) (*reconciler, error) {
+	gitClient, err := newGitClient(repo)
+	if err != nil {
+		return nil, fmt.Errorf("new git client: %v", err)
+	}
+
+	operator := &Operator{
+		name:        repo.Name,
+		git:         gitClient,
+		kc:          kubeClient,
+		kinds:       repo.Kinds,
+		namespaces:  repo.Namespaces,
+		allowCreate: conf.Operator.AllowCreate,
+		onlyManaged: conf.Operator.OnlyManaged,
+		applyMode:   cmp.Or(conf.Operator.ApplyMode, applyModeUpdate),
+		prune:       conf.Operator.Prune,
+		pruneDryRun: conf.Operator.PruneDryRun,
+	}
+
+	deployJobs := make(chan DeployJob, 16)
+	go func() {
+		const deployTimeout = 3 * time.Minute
+
+		for dj := range deployJobs {
+			select {
+			case <-dj.ctx.Done():
+				continue
+			default:
+			}
+
+			ctx, cancel := context.WithTimeout(dj.ctx, deployTimeout)
+			err := operator.cloneRepoAndDeploy(ctx, dj.after)
+			cancel()
+			if err != nil {
+				slog.Error("deploy", "repo", repo.Name, "err", err)
+			}
+
+			if dj.res != nil {
+				dj.res <- err
+			}
+		}
+	}()
+
+	return &reconciler{name: repo.Name, operator: operator, deployJobs: deployJobs}, nil
 }
 
 func main() {
@@ -78,9 +156,8 @@ func main() {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
 
-	gitClient, err := newGitClient(conf)
-	if err != nil {
-		fatal("new git client:", err)
+	if len(conf.Repos) == 0 {
+		fatal("at least one entry in repos is required")
 	}
 
 	kubeClient, err := newKubeClient(operatorName)
@@ -88,44 +165,47 @@ func main() {
 		fatal("new kube client", err)
 	}
 
-	operator := &Operator{
-		git:         gitClient,
-		kc:          kubeClient,
-		kinds:       conf.Operator.Kinds,
-		namespaces:  conf.Operator.Namespaces,
-		allowCreate: conf.Operator.AllowCreate,
-		onlyManaged: conf.Operator.OnlyManaged,
-	}
+	scheduler := job.NewScheduler()
 
-	if conf.Operator.CacheFileId {
-		operator.cacheIds = make(map[string]string)
+	restMapperJob := job.NewFunc("rest-mapper-refresh", func(ctx context.Context) error {
+		kubeClient.refreshRESTMapper()
+		return nil
+	})
+	if err := scheduler.Register(restMapperJob, conf.Operator.Schedule["rest-mapper-refresh"]); err != nil {
+		fatal("schedule rest-mapper-refresh job:", err)
 	}
 
-	deployJobs := make(chan DeployJob, 16)
-	go func() {
-		const deployTimeout = 3 * time.Minute
-
-		for {
-			job := <-deployJobs
+	reconcilers := make(map[string]*reconciler, len(conf.Repos))
+	var defaultRepo string
 
-			select {
-			case <-job.ctx.Done():
-				continue
-			default:
-			}
+	for i := range conf.Repos {
+		repo := &conf.Repos[i]
+		if repo.Name == "" {
+			fatal(fmt.Sprintf("repos[%d].name is required", i))
+		}
+		if _, exists := reconcilers[repo.Name]; exists {
+			fatal("duplicate repo name:", repo.Name)
+		}
 
-			ctx, cancel := context.WithTimeout(job.ctx, deployTimeout)
-			err := operator.cloneRepoAndDeploy(ctx)
-			cancel()
-			if err != nil {
-				slog.Error("deploy", "err", err)
-			}
+		rc, err := newReconciler(repo, conf, kubeClient)
+		if err != nil {
+			fatal(fmt.Sprintf("new reconciler for repo %s: %v", repo.Name, err))
+		}
+		reconcilers[repo.Name] = rc
+		if defaultRepo == "" {
+			defaultRepo = repo.Name
+		}
 
-			if job.res != nil {
-				job.res <- err
-			}
+		deployJob := job.NewFunc("deploy:"+repo.Name, func(ctx context.Context) error {
+			return enqueueDeploy(ctx, rc.deployJobs)
+		})
+		if err := scheduler.Register(deployJob, conf.Operator.Schedule["deploy:"+repo.Name]); err != nil {
+			fatal(fmt.Sprintf("schedule deploy job for repo %s: %v", repo.Name, err))
 		}
-	}()
+	}
+
+	scheduler.Start()
+	defer scheduler.Stop()
 
 	http.Handle("POST /deploy/trigger", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.FormValue("key") != conf.Http.Key {
@@ -133,9 +213,15 @@ func main() {
 			return
 		}
 
+		rc := reconcilers[cmp.Or(r.FormValue("repo"), defaultRepo)]
+		if rc == nil {
+			http.Error(w, "unknown repo", http.StatusNotFound)
+			return
+		}
+
 		if r.FormValue("sync") != "1" {
 			select {
-			case deployJobs <- DeployJob{ctx: context.Background()}:
+			case rc.deployJobs <- DeployJob{ctx: context.Background()}:
 				w.Write([]byte("triggered"))
 			case <-r.Context().Done():
 				slog.Info("deploy cancelled")
@@ -146,7 +232,7 @@ func main() {
 
 		res := make(chan error, 1)
 		select {
-		case deployJobs <- DeployJob{ctx: r.Context(), res: res}:
+		case rc.deployJobs <- DeployJob{ctx: r.Context(), res: res}:
 		case <-r.Context().Done():
 			slog.Info("deploy cancelled")
 			return
@@ -159,6 +245,7 @@ func main() {
 			w.Write([]byte("finished"))
 		}
 	}))
+	http.Handle("POST /deploy/webhook/{provider}/{repo}", deployWebhookHandler(conf, reconcilers))
 	err = http.ListenAndServe(conf.Http.Listen, nil)
 	if err != nil {
 		fatal("http listen:", err)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"io"
@@ -10,9 +11,12 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	yamlser "k8s.io/apimachinery/pkg/runtime/serializer/yaml"
@@ -22,25 +26,65 @@ const (
 	managedByLabel     = "app.kubernetes.io/managed-by"
 	commitIdAnnotation = "meta.knops/commit-id"
 	fileIdAnnotation   = "meta.knops/file-id"
+	pruneAnnotation    = "meta.knops/prune"
 )
 
+const (
+	applyModeUpdate = "update"
+	applyModeSSA    = "ssa"
+)
+
+// Source describes one thing in the repo tree to render into manifests.
+// Type is "raw" (default), "helm", or "kustomize" (not yet supported).
+type Source struct {
+	Type   string `yaml:"type"`
+	Path   string `yaml:"path"`
+	Values string `yaml:"values"`
+}
+
 type DotNops struct {
 	Namespace string            `yaml:"namespace"`
 	Labels    map[string]string `yaml:"labels"`
+	Sources   []Source          `yaml:"sources"`
+	// Env segments ownership so e.g. "staging" and "prod" don't fight over the same cluster.
+	Env string `yaml:"env"`
+}
+
+// managedByValue is the managedByLabel value for dotNops, scoped to dotNops.Env when set.
+func managedByValue(dotNops *DotNops) string {
+	if dotNops.Env == "" {
+		return operatorName
+	}
+	return operatorName + "-" + dotNops.Env
+}
+
+// fileIdAnnotationKey is the file-id annotation key, scoped to dotNops.Env when set.
+func fileIdAnnotationKey(dotNops *DotNops) string {
+	if dotNops.Env == "" {
+		return fileIdAnnotation
+	}
+	return fileIdAnnotation + "-" + dotNops.Env
 }
 
 type Operator struct {
+	name        string
 	git         *GitClient
 	kc          *KubeClient
-	cacheIds    map[string]string
 	kinds       []string
 	namespaces  []string
 	allowCreate bool
 	onlyManaged bool
+	applyMode   string
+	prune       bool
+	pruneDryRun bool
+
+	lastAppliedCommit string
 }
 
-func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId string, file *object.File) error {
-	desired, gvk, err := loadUnstructuredFromFile(file)
+// applyFile applies the manifest in data, identified by fileId for change
+// detection and the file-id annotation.
+func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId string, fileId string, data []byte) error {
+	desired, gvk, err := loadUnstructuredFromBytes(data)
 	if err != nil {
 		return err
 	}
@@ -61,13 +105,13 @@ func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId str
 
 	if actual != nil {
 		labels := actual.GetLabels()
-		if o.onlyManaged && labels[managedByLabel] != operatorName {
+		if o.onlyManaged && labels[managedByLabel] != managedByValue(dotNops) {
 			slog.Debug("ignore unmanaged object", "name", actual.GetName())
 			return nil
 		}
 
 		annotations := actual.GetAnnotations()
-		if annotations[fileIdAnnotation] == file.ID().String() {
+		if annotations[fileIdAnnotationKey(dotNops)] == fileId {
 			slog.Debug("ignore same object", "name", actual.GetName())
 			return nil
 		}
@@ -78,7 +122,7 @@ func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId str
 		labels = make(map[string]string)
 	}
 	maps.Copy(labels, dotNops.Labels)
-	labels[managedByLabel] = operatorName
+	labels[managedByLabel] = managedByValue(dotNops)
 	desired.SetLabels(labels)
 
 	annotations := desired.GetAnnotations()
@@ -86,9 +130,14 @@ func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId str
 		annotations = make(map[string]string)
 	}
 	annotations[commitIdAnnotation] = commitId
-	annotations[fileIdAnnotation] = file.ID().String()
+	annotations[fileIdAnnotationKey(dotNops)] = fileId
 	desired.SetAnnotations(annotations)
 
+	if o.applyMode == applyModeSSA {
+		_, err := o.kc.ssaApply(ctx, desired)
+		return err
+	}
+
 	if actual != nil {
 		desired.SetResourceVersion(actual.GetResourceVersion())
 		_, err := o.kc.update(ctx, desired)
@@ -105,7 +154,9 @@ func (o *Operator) applyFile(ctx context.Context, dotNops *DotNops, commitId str
 	return nil
 }
 
-func (o *Operator) deployCommit(ctx context.Context, commit *object.Commit) error {
+// deployCommit applies commit to the cluster, diffing against the last
+// applied commit when reachable instead of walking the whole tree.
+func (o *Operator) deployCommit(ctx context.Context, repo *git.Repository, commit *object.Commit) error {
 	tree, err := commit.Tree()
 	if err != nil {
 		return err
@@ -122,29 +173,152 @@ func (o *Operator) deployCommit(ctx context.Context, commit *object.Commit) erro
 
 	commitId := commit.ID().String()
 
-	err = tree.Files().ForEach(func(f *object.File) error {
+	if o.lastAppliedCommit == "" {
+		state, err := o.loadState(ctx, dotNops)
+		if err != nil {
+			slog.Warn("load deploy state", "err", err)
+		} else {
+			o.lastAppliedCommit = state.LastAppliedCommit
+		}
+	}
+
+	var changedPaths map[string]struct{}
+	if o.lastAppliedCommit != "" && o.lastAppliedCommit != commitId {
+		lastCommit, err := repo.CommitObject(plumbing.NewHash(o.lastAppliedCommit))
+		if err != nil {
+			slog.Info("last applied commit unreachable, doing full tree walk", "id", o.lastAppliedCommit, "err", err)
+		} else {
+			changedPaths, err = diffChangedPaths(lastCommit, commit)
+			if err != nil {
+				slog.Warn("diff commits, doing full tree walk", "err", err)
+				changedPaths = nil
+			}
+		}
+	}
+
+	seenFileIds := make(map[string]struct{})
+
+	sources := dotNops.Sources
+	if len(sources) == 0 {
+		sources = []Source{{Type: "raw", Path: "."}}
+	}
+
+	var sourceFailed bool
+	for _, src := range sources {
+		switch src.Type {
+		case "", "raw":
+			err = o.applyRawSource(ctx, tree, dotNops, commitId, src, changedPaths, seenFileIds)
+		case "helm":
+			err = o.applyHelmSource(ctx, tree, dotNops, commitId, src, seenFileIds)
+		case "kustomize":
+			// TODO: kustomize support
+			err = fmt.Errorf("source type kustomize is not yet supported")
+		default:
+			err = fmt.Errorf("unknown source type: %s", src.Type)
+		}
+		if err != nil {
+			slog.Warn("apply source", "type", src.Type, "path", src.Path, "err", err)
+			sourceFailed = true
+		}
+	}
+
+	// A failed source leaves seenFileIds incomplete, so skip pruning rather than risk deleting valid objects.
+	if o.prune {
+		if sourceFailed {
+			slog.Warn("skip prune, a source failed to apply", "commit", commitId)
+		} else if err := o.pruneRemoved(ctx, dotNops, commitId, seenFileIds); err != nil {
+			return fmt.Errorf("prune: %v", err)
+		}
+	}
+
+	// Don't advance past a commit that didn't fully apply, so the failed file gets retried next deploy.
+	if sourceFailed {
+		slog.Warn("source apply failed, not advancing deploy state", "commit", commitId)
+		return nil
+	}
+
+	err = o.saveState(ctx, dotNops, &DeployState{LastAppliedCommit: commitId})
+	if err != nil {
+		slog.Warn("save deploy state", "err", err)
+	}
+	o.lastAppliedCommit = commitId
+
+	return nil
+}
+
+// diffChangedPaths returns the paths added or modified between oldCommit
+// and newCommit; deletions are left to pruneRemoved.
+func diffChangedPaths(oldCommit, newCommit *object.Commit) (map[string]struct{}, error) {
+	oldTree, err := oldCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := newCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := oldTree.Diff(newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]struct{}, len(changes))
+	for _, c := range changes {
+		if c.To.Name != "" {
+			paths[c.To.Name] = struct{}{}
+		}
+	}
+
+	return paths, nil
+}
+
+// applyRawSource walks the YAML files under src.Path, applying the ones in
+// changedPaths (or all of them, if nil), and returns the first error hit
+// without stopping the walk.
+func (o *Operator) applyRawSource(ctx context.Context, tree *object.Tree, dotNops *DotNops, commitId string, src Source, changedPaths map[string]struct{}, seenFileIds map[string]struct{}) error {
+	root := tree
+	if src.Path != "" && src.Path != "." {
+		var err error
+		root, err = tree.Tree(src.Path)
+		if err != nil {
+			return fmt.Errorf("find path %s: %v", src.Path, err)
+		}
+	}
+
+	var firstErr error
+	err := root.Files().ForEach(func(f *object.File) error {
 		if !strings.HasSuffix(f.Name, ".yaml") || strings.HasPrefix(path.Base(f.Name), ".") {
 			return nil
 		}
 
 		fileId := f.ID().String()
-		slog.Debug("apply file", "file", f.Name, "id", fileId)
+		seenFileIds[fileId] = struct{}{}
 
-		if o.cacheIds != nil && o.cacheIds[f.Name] == fileId {
-			slog.Debug("ignore object by same cache id", "name", f.Name)
-			return nil
+		// f.Name is relative to root, not the commit's root tree; re-root it to match changedPaths.
+		treePath := f.Name
+		if src.Path != "" && src.Path != "." {
+			treePath = path.Join(src.Path, f.Name)
 		}
+		if changedPaths != nil {
+			if _, ok := changedPaths[treePath]; !ok {
+				return nil
+			}
+		}
+
+		slog.Debug("apply file", "file", treePath, "id", fileId)
 
-		err := o.applyFile(ctx, dotNops, commitId, f)
+		data, err := readTreeFile(f)
 		if err != nil {
-			slog.Warn("apply file", "file", f.Name, "err", err)
+			slog.Warn("read file", "file", treePath, "err", err)
+			firstErr = cmp.Or(firstErr, err)
 			return nil
 		}
 
-		// cache applied file id
-		if o.cacheIds != nil {
-			// TODO: concurrent map access
-			o.cacheIds[f.Name] = fileId
+		err = o.applyFile(ctx, dotNops, commitId, fileId, data)
+		if err != nil {
+			slog.Warn("apply file", "file", treePath, "err", err)
+			firstErr = cmp.Or(firstErr, err)
 		}
 
 		return nil
@@ -152,13 +326,67 @@ func (o *Operator) deployCommit(ctx context.Context, commit *object.Commit) erro
 	if err != nil {
 		return err
 	}
+	return firstErr
+}
 
-	// TODO: remove deleted file id
+// pruneRemoved deletes managed objects whose file-id annotation is no
+// longer in seenFileIds, skipping objects applied by commitId itself or
+// opted out via pruneAnnotation.
+func (o *Operator) pruneRemoved(ctx context.Context, dotNops *DotNops, commitId string, seenFileIds map[string]struct{}) error {
+	fileIdKey := fileIdAnnotationKey(dotNops)
+	listOpts := metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue(dotNops),
+	}
+
+	for _, kind := range o.kinds {
+		gvk, err := o.kc.resolveKind(kind)
+		if err != nil {
+			slog.Warn("resolve kind for prune", "kind", kind, "err", err)
+			continue
+		}
+
+		list, err := o.kc.list(ctx, gvk, dotNops.Namespace, listOpts)
+		if err != nil {
+			slog.Warn("list for prune", "kind", kind, "err", err)
+			continue
+		}
+
+		for _, obj := range list.Items {
+			annotations := obj.GetAnnotations()
+			if annotations[pruneAnnotation] == "false" {
+				continue
+			}
+			if annotations[commitIdAnnotation] == commitId {
+				continue
+			}
+			if _, ok := seenFileIds[annotations[fileIdKey]]; ok {
+				continue
+			}
+
+			if o.pruneDryRun {
+				slog.Info("prune (dry-run)", "kind", kind, "name", obj.GetName())
+				continue
+			}
+
+			slog.Info("prune", "kind", kind, "name", obj.GetName())
+			err := o.kc.delete(ctx, obj.GroupVersionKind(), dotNops.Namespace, obj.GetName())
+			if err != nil {
+				slog.Warn("prune", "kind", kind, "name", obj.GetName(), "err", err)
+			}
+		}
+	}
 
 	return nil
 }
 
-func (o *Operator) cloneRepoAndDeploy(ctx context.Context) error {
+// cloneRepoAndDeploy clones the repo and deploys its HEAD commit, skipping
+// entirely if after already matches the last applied commit.
+func (o *Operator) cloneRepoAndDeploy(ctx context.Context, after string) error {
+	if after != "" && after == o.lastAppliedCommit {
+		slog.Info("skip deploy, already applied", "id", after)
+		return nil
+	}
+
 	repo, err := o.git.clone(ctx)
 	if err != nil {
 		return err
@@ -174,9 +402,14 @@ func (o *Operator) cloneRepoAndDeploy(ctx context.Context) error {
 		return err
 	}
 
+	if head.ID().String() == o.lastAppliedCommit {
+		slog.Info("skip deploy, HEAD already applied", "id", head.ID())
+		return nil
+	}
+
 	slog.Info("deploy HEAD commit", "id", head.ID(), "message", strings.SplitN(head.Message, "\n", 2)[0])
 
-	err = o.deployCommit(ctx, head)
+	err = o.deployCommit(ctx, repo, head)
 	if err != nil {
 		return err
 	}
@@ -211,17 +444,18 @@ func loadDotNopsFromTree(tree *object.Tree) (*DotNops, error) {
 	return &dotNops, nil
 }
 
-func loadUnstructuredFromFile(file *object.File) (*unstructured.Unstructured, *schema.GroupVersionKind, error) {
+// readTreeFile reads the full contents of a file blob in a git tree.
+func readTreeFile(file *object.File) ([]byte, error) {
 	rd, err := file.Reader()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer rd.Close()
 
-	data, err := io.ReadAll(rd)
-	if err != nil {
-		return nil, nil, err
-	}
+	return io.ReadAll(rd)
+}
+
+func loadUnstructuredFromBytes(data []byte) (*unstructured.Unstructured, *schema.GroupVersionKind, error) {
 	dec := yamlser.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
 	var obj unstructured.Unstructured
 	_, gvk, err := dec.Decode(data, nil, &obj)
@@ -0,0 +1,80 @@
+// Package job is a small cron-driven scheduler for background tasks.
+package job
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Job is a named unit of work that a Scheduler can run on a schedule.
+type Job interface {
+	Name() string
+	Run(ctx context.Context) error
+}
+
+// Spec configures how a Job is scheduled.
+type Spec struct {
+	Cron    string `yaml:"cron"`
+	Enabled bool   `yaml:"enabled"`
+}
+
+// Func adapts a function to the Job interface.
+type Func struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// NewFunc returns a Job named name that runs fn.
+func NewFunc(name string, fn func(ctx context.Context) error) *Func {
+	return &Func{name: name, fn: fn}
+}
+
+func (f *Func) Name() string { return f.name }
+
+func (f *Func) Run(ctx context.Context) error { return f.fn(ctx) }
+
+// Scheduler runs registered Jobs according to their configured cron spec.
+type Scheduler struct {
+	cron *cron.Cron
+}
+
+// NewScheduler returns a Scheduler with no jobs registered.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		cron: cron.New(),
+	}
+}
+
+// Register schedules job according to spec; a disabled spec is a no-op.
+func (s *Scheduler) Register(j Job, spec Spec) error {
+	if !spec.Enabled {
+		slog.Debug("job disabled", "job", j.Name())
+		return nil
+	}
+
+	_, err := s.cron.AddFunc(spec.Cron, func() {
+		slog.Info("run scheduled job", "job", j.Name())
+		if err := j.Run(context.Background()); err != nil {
+			slog.Error("scheduled job failed", "job", j.Name(), "err", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("schedule job %s: %w", j.Name(), err)
+	}
+
+	return nil
+}
+
+// Start runs the scheduler in its own goroutine.
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop halts the scheduler without waiting; the returned context is done
+// once any running job has finished.
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const stateConfigMapNamePrefix = "knops-state"
+
+var configMapGVK = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+// DeployState is the operator's own deploy bookkeeping, persisted as a ConfigMap so it survives restarts.
+type DeployState struct {
+	LastAppliedCommit string `json:"lastAppliedCommit"`
+}
+
+// stateConfigMapName is scoped by repo name and by dotNops.Env (the latter as managedByValue is).
+func (o *Operator) stateConfigMapName(dotNops *DotNops) string {
+	name := stateConfigMapNamePrefix
+	if o.name != "" {
+		name += "-" + o.name
+	}
+	if dotNops.Env != "" {
+		name += "-" + dotNops.Env
+	}
+	return name
+}
+
+func (o *Operator) loadState(ctx context.Context, dotNops *DotNops) (*DeployState, error) {
+	name := o.stateConfigMapName(dotNops)
+	cm, err := o.kc.get(ctx, configMapGVK, dotNops.Namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return &DeployState{}, nil
+		}
+		return nil, err
+	}
+
+	raw, _, err := unstructured.NestedString(cm.Object, "data", "state")
+	if err != nil || raw == "" {
+		return &DeployState{}, nil
+	}
+
+	var state DeployState
+	err = json.Unmarshal([]byte(raw), &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (o *Operator) saveState(ctx context.Context, dotNops *DotNops, state *DeployState) error {
+	name := o.stateConfigMapName(dotNops)
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace(dotNops.Namespace)
+	cm.SetName(name)
+	err = unstructured.SetNestedField(cm.Object, map[string]any{"state": string(raw)}, "data")
+	if err != nil {
+		return err
+	}
+
+	actual, err := o.kc.get(ctx, configMapGVK, dotNops.Namespace, name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		_, err = o.kc.create(ctx, cm)
+		return err
+	}
+
+	cm.SetResourceVersion(actual.GetResourceVersion())
+	_, err = o.kc.update(ctx, cm)
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type pushEvent struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// verifyHMACSHA256 reports whether sigHex is the hex HMAC-SHA256 of body under secret.
+func verifyHMACSHA256(secret string, body []byte, sigHex string) bool {
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// verifyWebhook checks the provider-specific signature of a push request.
+func verifyWebhook(provider string, secret string, body []byte, header http.Header) bool {
+	switch provider {
+	case "github":
+		return verifyHMACSHA256(secret, body, strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="))
+	case "gitea":
+		return verifyHMACSHA256(secret, body, header.Get("X-Gitea-Signature"))
+	case "gitlab":
+		return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	default:
+		return false
+	}
+}
+
+// deployWebhookHandler handles POST /deploy/webhook/{provider}/{repo}, enqueuing a
+// deploy on the named repo's reconciler once the push event verifies and its ref matches.
+func deployWebhookHandler(conf *Config, reconcilers map[string]*reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := r.PathValue("provider")
+		secret, ok := conf.Http.WebhookSecrets[provider]
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		rc, ok := reconcilers[r.PathValue("repo")]
+		if !ok {
+			http.Error(w, "unknown repo", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if !verifyWebhook(provider, secret, body, r.Header) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		var event pushEvent
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		if event.Ref != "refs/heads/"+rc.operator.git.branch {
+			slog.Debug("webhook ref mismatch", "provider", provider, "repo", rc.name, "ref", event.Ref)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		select {
+		case rc.deployJobs <- DeployJob{ctx: context.Background(), after: event.After}:
+		case <-r.Context().Done():
+			slog.Info("deploy cancelled")
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(event.After))
+	}
+}